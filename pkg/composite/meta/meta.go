@@ -24,25 +24,31 @@ import (
 	"unicode"
 )
 
-const (
-	// This assumes that alpha contains a superset of all struct fields
-	apiFilePath = "./vendor/google.golang.org/api/compute/v0.alpha/compute-api.json"
-)
+// apiFileDir is the vendored directory holding one discovery doc per
+// Compute API version.
+const apiFileDir = "./vendor/google.golang.org/api/compute"
+
+// apiFilePath returns the discovery doc path for versionName, one of the
+// keys of Versions. GA is special-cased since its path segment ("v1") isn't
+// derived the same way as Alpha/Beta ("v0.<segment>").
+func apiFilePath(versionName string) string {
+	if versionName == "GA" {
+		return apiFileDir + "/v1/compute-api.json"
+	}
+	return apiFileDir + "/v0." + Versions[versionName] + "/compute-api.json"
+}
 
 // MainServices describes all of the API types that we want to define all the helper functions for
 // The other types that are discovered as dependencies will simply be wrapped with a composite struct
 // The format of the map is ServiceName -> k8s-cloud-provider wrapper name
-// TODO: (shance) Add the commented services and remove dependency on first cloud-provider layer
 var MainServices = map[string]string{
-	"BackendService": "BackendServices",
-	/*
-		"ForwardingRule":   "ForwardingRules",
-		"HttpHealthCheck":  "HttpHealthChecks",
-		"HttpsHealthCheck": "HttpsHealthChecks",
-		"UrlMap":           "UrlMaps",
-		"TargetHttpProxy":  "TargetHttpProxies",
-		"TargetHttpsProxy": "TargetHttpsProxies",
-	*/
+	"BackendService":   "BackendServices",
+	"ForwardingRule":   "ForwardingRules",
+	"HttpHealthCheck":  "HttpHealthChecks",
+	"HttpsHealthCheck": "HttpsHealthChecks",
+	"UrlMap":           "UrlMaps",
+	"TargetHttpProxy":  "TargetHttpProxies",
+	"TargetHttpsProxy": "TargetHttpsProxies",
 }
 
 // TODO: (shance) Replace this with data gathered from meta.AllServices
@@ -53,6 +59,9 @@ var NoUpdate = sets.NewString(
 	"TargetHttpsProxy",
 )
 
+// Versions maps the Go-facing version name used in composite struct names
+// (e.g. BackendServiceAlpha) to the path segment used in the discovery doc
+// and k8s-cloud-provider API, GA being the unversioned default.
 var Versions = map[string]string{
 	"Alpha": "alpha",
 	"Beta":  "beta",
@@ -73,6 +82,10 @@ type ApiService struct {
 	VarName string
 	// All of the struct fields
 	Fields []ApiService
+	// Version this definition was generated from (Alpha, Beta or GA). A
+	// field absent from a version's discovery doc simply never appears
+	// here; there is no separate per-field version marker to check.
+	Version string
 }
 
 // IsMainService() returns true if the service name is in the MainServices map
@@ -96,7 +109,25 @@ func (apiService *ApiService) GetCloudProviderName() string {
 	return result
 }
 
-var AllApiServices []ApiService
+// CommonFields returns the Fields that apiService shares with other,
+// matched by JsonName. This is what drives the generated Alpha<->Beta<->GA
+// conversion helpers: only fields present on both sides of a conversion
+// can be copied, the rest are version-specific and must be dropped or
+// zeroed.
+func (apiService *ApiService) CommonFields(other ApiService) []ApiService {
+	otherFields := make(map[string]ApiService, len(other.Fields))
+	for _, f := range other.Fields {
+		otherFields[f.JsonName] = f
+	}
+
+	var common []ApiService
+	for _, f := range apiService.Fields {
+		if _, ok := otherFields[f.JsonName]; ok {
+			common = append(common, f)
+		}
+	}
+	return common
+}
 
 // createVarName() converts the service name into camelcase
 func createVarName(str string) string {
@@ -109,10 +140,23 @@ func createVarName(str string) string {
 	return string(copy)
 }
 
-// populateApiServices() parses the Api Spec and populates AllApiServices with the required services
-// Performs BFS to resolve dependencies
-func populateApiServices() {
-	apiFile, err := os.Open(apiFilePath)
+// AllApiServicesByVersion holds, for each of Alpha/Beta/GA, the composite
+// definitions for MainServices and everything they transitively reference
+// in that version's discovery doc.
+var AllApiServicesByVersion map[string][]ApiService
+
+// AllApiServices is the Alpha definitions alone, kept for callers that
+// predate per-version output; no union of fields across versions is
+// computed, so a field present only in Beta or GA does not appear here.
+var AllApiServices []ApiService
+
+// populateApiServices() parses the discovery doc for version and returns
+// the composite definitions for MainServices and everything discovered as
+// a dependency. Performs BFS to resolve dependencies. Fields that a given
+// version's discovery doc doesn't define for a type are simply never
+// visited, since the BFS only walks that version's own properties map.
+func populateApiServices(version, discoveryDocPath string) []ApiService {
+	apiFile, err := os.Open(discoveryDocPath)
 	if err != nil {
 		panic(err)
 	}
@@ -133,6 +177,8 @@ func populateApiServices() {
 	// Go type of the property
 	var propType string
 
+	var allApiServices []ApiService
+
 	keys := []string{}
 	for key := range MainServices {
 		keys = append(keys, key)
@@ -148,18 +194,26 @@ func populateApiServices() {
 		}
 		completed.Insert(typeName)
 
-		fields, ok := result["schemas"].(map[string]interface{})[typeName].(map[string]interface{})["properties"].(map[string]interface{})
+		schema, ok := result["schemas"].(map[string]interface{})[typeName].(map[string]interface{})
+		if !ok {
+			// typeName isn't defined in this version's discovery doc at
+			// all (e.g. an Alpha-only type referenced while generating
+			// GA); there's nothing to generate for it in this version.
+			continue
+		}
+
+		fields, ok := schema["properties"].(map[string]interface{})
 		if !ok {
 			panic(fmt.Errorf("Unable to parse type: %s", typeName))
 		}
 
-		apiService := ApiService{Name: typeName, Fields: []ApiService{}, VarName: createVarName(typeName)}
+		apiService := ApiService{Name: typeName, Fields: []ApiService{}, VarName: createVarName(typeName), Version: version}
 
 		for prop, val := range fields {
 			subType := ApiService{Name: strings.Title(prop), JsonName: prop}
 
 			var override bool
-			propType, typesQueue, override, err = getGoType(val, typesQueue)
+			propType, typesQueue, override, err = getGoType(subType.Name, val, typesQueue)
 			if err != nil {
 				panic(err)
 			}
@@ -173,18 +227,83 @@ func populateApiServices() {
 			return apiService.Fields[i].Name < apiService.Fields[j].Name
 		})
 
-		AllApiServices = append(AllApiServices, apiService)
+		allApiServices = append(allApiServices, apiService)
 	}
 
 	// Sort the struct definitions since the keys aren't ordered deterministically
-	sort.Slice(AllApiServices[:], func(i, j int) bool {
-		return AllApiServices[i].Name < AllApiServices[j].Name
+	sort.Slice(allApiServices[:], func(i, j int) bool {
+		return allApiServices[i].Name < allApiServices[j].Name
 	})
+
+	return allApiServices
+}
+
+// EnumValue is one legal value of an enum field, alongside the Go constant
+// identifier a template should declare for it, e.g. {ConstName:
+// "LoadBalancingSchemeInternal", Value: "INTERNAL"}.
+type EnumValue struct {
+	ConstName string
+	Value     string
+}
+
+// EnumType is a typed Go string alias generated for a discovery doc field
+// that declares an `enum`, plus the constants for each of its legal values.
+type EnumType struct {
+	// Name is both the Go type name and the field name it was generated
+	// for, e.g. LoadBalancingScheme.
+	Name   string
+	Values []EnumValue
+}
+
+// EnumTypes collects every enum type discovered while generating
+// composites, keyed by Name. The same enum field (e.g. LoadBalancingScheme
+// on both BackendService and UrlMap) is discovered more than once across
+// services, so this is a map rather than a slice to naturally de-dupe.
+var EnumTypes = map[string]EnumType{}
+
+// registerEnumType records fieldName as an enum type with the legal values
+// from rawEnum (the JSON `enum` array for that field) and returns the Go
+// type name callers should use in place of a bare "string".
+func registerEnumType(fieldName string, rawEnum interface{}) string {
+	rawValues, ok := rawEnum.([]interface{})
+	if !ok {
+		panic(fmt.Errorf("enum field %s has non-array enum values: %v", fieldName, rawEnum))
+	}
+
+	enumType := EnumType{Name: fieldName}
+	for _, rawValue := range rawValues {
+		value, ok := rawValue.(string)
+		if !ok {
+			panic(fmt.Errorf("enum field %s has non-string enum value: %v", fieldName, rawValue))
+		}
+		enumType.Values = append(enumType.Values, EnumValue{
+			ConstName: enumConstName(fieldName, value),
+			Value:     value,
+		})
+	}
+
+	EnumTypes[fieldName] = enumType
+	return fieldName
+}
+
+// enumConstName turns an enum field name and one of its SCREAMING_SNAKE_CASE
+// values into a Go constant identifier, e.g. ("LoadBalancingScheme",
+// "INVALID_IAP") -> "LoadBalancingSchemeInvalidIap".
+func enumConstName(typeName, value string) string {
+	words := strings.Split(value, "_")
+	for i, word := range words {
+		if word == "" {
+			continue
+		}
+		words[i] = strings.ToUpper(word[:1]) + strings.ToLower(word[1:])
+	}
+	return typeName + strings.Join(words, "")
 }
 
 // getGoType() determines what the golang type is for a service by recursively descending the API spec json
-// for a field.  Since this may discover new types, it also updates the typesQueue.
-func getGoType(val interface{}, typesQueue []string) (string, []string, bool, error) {
+// for a field.  Since this may discover new types, it also updates the typesQueue. fieldName is the Go name
+// of the field being resolved (e.g. "LoadBalancingScheme"), used to name any enum type it declares.
+func getGoType(fieldName string, val interface{}, typesQueue []string) (string, []string, bool, error) {
 	field, ok := val.(map[string]interface{})
 	if !ok {
 		panic(nil)
@@ -195,31 +314,43 @@ func getGoType(val interface{}, typesQueue []string) (string, []string, bool, er
 	var override bool
 
 	propType := ""
-	ref, ok := field["$ref"]
-	// Field is not a built-in type, we need to wrap it
-	if ok {
+	if ref, ok := field["$ref"]; ok {
+		// Field is not a built-in type, we need to wrap it
 		refName := ref.(string)
 		typesQueue = append(typesQueue, refName)
 		propType = "*" + refName
 	} else if field["type"] == "array" {
-		tmpType, typesQueue, override, err = getGoType(field["items"], typesQueue)
+		tmpType, typesQueue, override, err = getGoType(fieldName, field["items"], typesQueue)
 		propType = "[]" + tmpType
 	} else if field["type"] == "object" {
 		addlProps, ok := field["additionalProperties"]
 		if ok {
-			tmpType, typesQueue, override, err = getGoType(addlProps, typesQueue)
+			tmpType, typesQueue, override, err = getGoType(fieldName, addlProps, typesQueue)
 			propType = "map[string]" + tmpType
 		} else {
 			propType = "map[string]string"
 		}
+	} else if rawEnum, ok := field["enum"]; ok {
+		// Enum fields are declared as type "string" with an "enum" array
+		// of legal values; generate a named string alias + constants
+		// instead of flattening them to a bare string.
+		propType = registerEnumType(fieldName, rawEnum)
 	} else if format, ok := field["format"]; ok {
-		if format.(string) == "byte" {
+		switch format.(string) {
+		case "byte":
 			propType = "string"
-		} else if format.(string) == "float" {
+		case "float":
 			propType = "float64"
-		} else if format.(string) == "int32" {
+		case "int32":
+			// Preserve int32 as-is rather than widening it to int64.
+			propType = "int32"
+		case "int64":
+			// The discovery doc emits int64 fields as JSON strings (JSON
+			// numbers aren't precise enough), so marshal/unmarshal needs
+			// the ",string" tag override alongside the int64 Go type.
 			propType = "int64"
-		} else {
+			override = true
+		default:
 			propType = format.(string)
 		}
 	} else if field["type"] != "" {
@@ -232,14 +363,80 @@ func getGoType(val interface{}, typesQueue []string) (string, []string, bool, er
 		err = fmt.Errorf("unable to get property type for prop: %v", val)
 	}
 
-	if field["type"] == "string" && propType != "string" {
-		override = true
-	}
-
 	return propType, typesQueue, override, err
 }
 
 func init() {
-	AllApiServices = []ApiService{}
-	populateApiServices()
+	AllApiServicesByVersion = map[string][]ApiService{}
+	for versionName := range Versions {
+		AllApiServicesByVersion[versionName] = populateApiServices(versionName, apiFilePath(versionName))
+	}
+	AllApiServices = AllApiServicesByVersion["Alpha"]
+}
+
+// ConversionSpec describes the fields that can be safely copied when
+// converting a MainServices type from FromVersion to ToVersion: only fields
+// present in both versions' discovery docs survive a round-trip, so
+// generated conversion helpers copy exactly these Fields and drop the rest.
+type ConversionSpec struct {
+	Service     string
+	FromVersion string
+	ToVersion   string
+	Fields      []ApiService
+}
+
+// ConversionSpecs returns, for every MainServices type and every ordered
+// pair of versions that both define it, the ConversionSpec driving the
+// generated Alpha<->Beta<->GA conversion helper for that pair.
+func ConversionSpecs() []ConversionSpec {
+	versionNames := make([]string, 0, len(Versions))
+	for versionName := range Versions {
+		versionNames = append(versionNames, versionName)
+	}
+	sort.Strings(versionNames)
+
+	byName := func(versionName string) map[string]ApiService {
+		services := make(map[string]ApiService, len(AllApiServicesByVersion[versionName]))
+		for _, svc := range AllApiServicesByVersion[versionName] {
+			services[svc.Name] = svc
+		}
+		return services
+	}
+
+	var specs []ConversionSpec
+	for serviceName := range MainServices {
+		for _, fromVersion := range versionNames {
+			fromSvc, ok := byName(fromVersion)[serviceName]
+			if !ok {
+				continue
+			}
+			for _, toVersion := range versionNames {
+				if toVersion == fromVersion {
+					continue
+				}
+				toSvc, ok := byName(toVersion)[serviceName]
+				if !ok {
+					continue
+				}
+				specs = append(specs, ConversionSpec{
+					Service:     serviceName,
+					FromVersion: fromVersion,
+					ToVersion:   toVersion,
+					Fields:      fromSvc.CommonFields(toSvc),
+				})
+			}
+		}
+	}
+
+	sort.Slice(specs, func(i, j int) bool {
+		if specs[i].Service != specs[j].Service {
+			return specs[i].Service < specs[j].Service
+		}
+		if specs[i].FromVersion != specs[j].FromVersion {
+			return specs[i].FromVersion < specs[j].FromVersion
+		}
+		return specs[i].ToVersion < specs[j].ToVersion
+	})
+
+	return specs
 }
@@ -0,0 +1,243 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meta
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// mustField unmarshals a compute-api.json-style schema fragment, e.g.
+// `{"type": "string", "format": "int64"}`, the same shape getGoType sees
+// for a single property while walking the real discovery doc.
+func mustField(t *testing.T, fixture string) map[string]interface{} {
+	t.Helper()
+	var field map[string]interface{}
+	if err := json.Unmarshal([]byte(fixture), &field); err != nil {
+		t.Fatalf("invalid fixture JSON %q: %v", fixture, err)
+	}
+	return field
+}
+
+func TestGetGoType(t *testing.T) {
+	tests := []struct {
+		name         string
+		fieldName    string
+		fixture      string
+		wantType     string
+		wantOverride bool
+	}{
+		{
+			name:      "int32 format is preserved, not widened",
+			fieldName: "Priority",
+			fixture:   `{"type": "integer", "format": "int32"}`,
+			wantType:  "int32",
+		},
+		{
+			name:         "int64 format emits int64 with a string tag override",
+			fieldName:    "CreationTimestampUsec",
+			fixture:      `{"type": "string", "format": "int64"}`,
+			wantType:     "int64",
+			wantOverride: true,
+		},
+		{
+			name:      "float format",
+			fieldName: "Ratio",
+			fixture:   `{"type": "number", "format": "float"}`,
+			wantType:  "float64",
+		},
+		{
+			name:      "byte format",
+			fieldName: "Payload",
+			fixture:   `{"type": "string", "format": "byte"}`,
+			wantType:  "string",
+		},
+		{
+			name:      "boolean",
+			fieldName: "EnableCdn",
+			fixture:   `{"type": "boolean"}`,
+			wantType:  "bool",
+		},
+		{
+			name:      "plain string",
+			fieldName: "Description",
+			fixture:   `{"type": "string"}`,
+			wantType:  "string",
+		},
+		{
+			name:      "$ref wraps the referenced type as a pointer",
+			fieldName: "BackendRef",
+			fixture:   `{"$ref": "Backend"}`,
+			wantType:  "*Backend",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			field := mustField(t, tc.fixture)
+			gotType, _, gotOverride, err := getGoType(tc.fieldName, field, nil)
+			if err != nil {
+				t.Fatalf("getGoType() returned error: %v", err)
+			}
+			if gotType != tc.wantType {
+				t.Errorf("type = %q, want %q", gotType, tc.wantType)
+			}
+			if gotOverride != tc.wantOverride {
+				t.Errorf("override = %v, want %v", gotOverride, tc.wantOverride)
+			}
+		})
+	}
+}
+
+func TestGetGoTypeArrayOfRefs(t *testing.T) {
+	field := mustField(t, `{"type": "array", "items": {"$ref": "Backend"}}`)
+
+	gotType, typesQueue, _, err := getGoType("Backends", field, nil)
+	if err != nil {
+		t.Fatalf("getGoType() returned error: %v", err)
+	}
+	if gotType != "[]*Backend" {
+		t.Errorf("type = %q, want %q", gotType, "[]*Backend")
+	}
+	if !reflect.DeepEqual(typesQueue, []string{"Backend"}) {
+		t.Errorf("typesQueue = %v, want [Backend]", typesQueue)
+	}
+}
+
+func TestGetGoTypeObjectAdditionalProperties(t *testing.T) {
+	field := mustField(t, `{"type": "object", "additionalProperties": {"type": "string"}}`)
+
+	gotType, _, _, err := getGoType("Labels", field, nil)
+	if err != nil {
+		t.Fatalf("getGoType() returned error: %v", err)
+	}
+	if gotType != "map[string]string" {
+		t.Errorf("type = %q, want %q", gotType, "map[string]string")
+	}
+}
+
+func TestGetGoTypeEnum(t *testing.T) {
+	EnumTypes = map[string]EnumType{}
+
+	field := mustField(t, `{"type": "string", "enum": ["INTERNAL", "EXTERNAL", "INVALID_IAP"]}`)
+
+	gotType, _, override, err := getGoType("LoadBalancingScheme", field, nil)
+	if err != nil {
+		t.Fatalf("getGoType() returned error: %v", err)
+	}
+	if gotType != "LoadBalancingScheme" {
+		t.Errorf("type = %q, want %q", gotType, "LoadBalancingScheme")
+	}
+	if override {
+		t.Errorf("override = true, want false for an enum field")
+	}
+
+	enumType, ok := EnumTypes["LoadBalancingScheme"]
+	if !ok {
+		t.Fatalf("EnumTypes[%q] was not registered", "LoadBalancingScheme")
+	}
+	wantValues := []EnumValue{
+		{ConstName: "LoadBalancingSchemeInternal", Value: "INTERNAL"},
+		{ConstName: "LoadBalancingSchemeExternal", Value: "EXTERNAL"},
+		{ConstName: "LoadBalancingSchemeInvalidIap", Value: "INVALID_IAP"},
+	}
+	if !reflect.DeepEqual(enumType.Values, wantValues) {
+		t.Errorf("EnumTypes[%q].Values = %+v, want %+v", "LoadBalancingScheme", enumType.Values, wantValues)
+	}
+}
+
+func TestApiFilePath(t *testing.T) {
+	tests := []struct {
+		versionName string
+		want        string
+	}{
+		{"Alpha", "./vendor/google.golang.org/api/compute/v0.alpha/compute-api.json"},
+		{"Beta", "./vendor/google.golang.org/api/compute/v0.beta/compute-api.json"},
+		{"GA", "./vendor/google.golang.org/api/compute/v1/compute-api.json"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.versionName, func(t *testing.T) {
+			if got := apiFilePath(tc.versionName); got != tc.want {
+				t.Errorf("apiFilePath(%q) = %q, want %q", tc.versionName, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCommonFields(t *testing.T) {
+	alpha := ApiService{
+		Name: "BackendService",
+		Fields: []ApiService{
+			{Name: "Description", JsonName: "description"},
+			{Name: "AlphaOnly", JsonName: "alphaOnly"},
+		},
+	}
+	ga := ApiService{
+		Name: "BackendService",
+		Fields: []ApiService{
+			{Name: "Description", JsonName: "description"},
+			{Name: "GaOnly", JsonName: "gaOnly"},
+		},
+	}
+
+	common := alpha.CommonFields(ga)
+	if len(common) != 1 || common[0].JsonName != "description" {
+		t.Errorf("CommonFields() = %+v, want a single field with JsonName %q", common, "description")
+	}
+}
+
+func TestConversionSpecs(t *testing.T) {
+	origVersions, origMainServices, origByVersion := Versions, MainServices, AllApiServicesByVersion
+	defer func() {
+		Versions, MainServices, AllApiServicesByVersion = origVersions, origMainServices, origByVersion
+	}()
+
+	Versions = map[string]string{"Alpha": "alpha", "GA": ""}
+	MainServices = map[string]string{"BackendService": "BackendServices"}
+	AllApiServicesByVersion = map[string][]ApiService{
+		"Alpha": {
+			{
+				Name: "BackendService",
+				Fields: []ApiService{
+					{Name: "Description", JsonName: "description"},
+					{Name: "AlphaOnly", JsonName: "alphaOnly"},
+				},
+			},
+		},
+		"GA": {
+			{
+				Name: "BackendService",
+				Fields: []ApiService{
+					{Name: "Description", JsonName: "description"},
+					{Name: "GaOnly", JsonName: "gaOnly"},
+				},
+			},
+		},
+	}
+
+	specs := ConversionSpecs()
+	if len(specs) != 2 {
+		t.Fatalf("len(ConversionSpecs()) = %d, want 2 (Alpha->GA and GA->Alpha)", len(specs))
+	}
+
+	want := []ConversionSpec{
+		{Service: "BackendService", FromVersion: "Alpha", ToVersion: "GA", Fields: []ApiService{{Name: "Description", JsonName: "description"}}},
+		{Service: "BackendService", FromVersion: "GA", ToVersion: "Alpha", Fields: []ApiService{{Name: "Description", JsonName: "description"}}},
+	}
+	if !reflect.DeepEqual(specs, want) {
+		t.Errorf("ConversionSpecs() = %+v, want %+v", specs, want)
+	}
+}
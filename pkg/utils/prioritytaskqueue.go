@@ -0,0 +1,306 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	tqmetrics "k8s.io/ingress-gce/pkg/utils/metrics"
+)
+
+// coalesceWindow is the minimum time a freshly (or newly re-) enqueued key
+// spends in the pending heap before becoming eligible for dispatch, giving
+// repeated enqueues of the same key, including EnqueueAfter(obj, 0), a real
+// window in which to collapse into one pending entry rather than relying on
+// the dispatcher goroutine not yet having raced ahead.
+const coalesceWindow = 10 * time.Millisecond
+
+// Priority is a coarse tier used to order pending work in a
+// PriorityTaskQueue. Within the set of keys currently waiting to be
+// dispatched, higher-priority keys are dispatched before lower-priority
+// ones; ties are broken by arrival order.
+type Priority int
+
+const (
+	// PriorityGC is for background sweeps, e.g. garbage-collecting
+	// orphaned BackendServices, that can wait behind user-facing work.
+	// It is the zero value, so Enqueue/EnqueueAfter calls that don't
+	// specify a priority behave the same as they did before priorities
+	// existed.
+	PriorityGC Priority = iota
+	// PriorityUserFacing is for syncs triggered by a user directly
+	// changing a resource (e.g. editing an Ingress). These preempt
+	// already-queued PriorityGC work.
+	PriorityUserFacing
+)
+
+// priorityItem is one key waiting to become due for dispatch.
+type priorityItem struct {
+	key      string
+	priority Priority
+	readyAt  time.Time
+	seq      uint64
+	index    int
+}
+
+// priorityHeap orders items by priority descending, then by readyAt/seq
+// ascending so that, among items of equal priority, older requests win.
+type priorityHeap []*priorityItem
+
+func (h priorityHeap) Len() int { return len(h) }
+
+func (h priorityHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	if !h[i].readyAt.Equal(h[j].readyAt) {
+		return h[i].readyAt.Before(h[j].readyAt)
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h priorityHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *priorityHeap) Push(x interface{}) {
+	item := x.(*priorityItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *priorityHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*h = old[:n-1]
+	return item
+}
+
+// PriorityTaskQueue is a TaskQueue that supports prioritized and debounced
+// enqueues on top of a standard rate-limiting work queue. A background
+// dispatcher holds keys in a priority heap until they're due, then hands
+// them to the underlying work queue in priority order; repeated enqueues of
+// the same key before it's dispatched collapse into a single pending entry,
+// so a BackendService that fires ten dependent events in a row still only
+// syncs once.
+type PriorityTaskQueue struct {
+	resource string
+	keyFunc  func(obj interface{}) (string, error)
+	sync     SyncFunc
+	logger   logr.Logger
+
+	// queue is handed exactly one key, in priority order, each time the
+	// dispatcher decides it's due; it then owns the usual
+	// Get/Done/AddRateLimited/Forget lifecycle for that key.
+	queue workqueue.RateLimitingInterface
+
+	mu      sync.Mutex
+	pending map[string]*priorityItem
+	waiting priorityHeap
+	seq     uint64
+
+	wake         chan struct{}
+	stopC        chan struct{}
+	stopOnce     sync.Once
+	dispatchDone chan struct{}
+	workerDone   chan struct{}
+}
+
+// NewPriorityTaskQueue creates a new PriorityTaskQueue with the default
+// rate limiter.
+func NewPriorityTaskQueue(name, resource string, syncFn SyncFunc) *PriorityTaskQueue {
+	rl := workqueue.DefaultControllerRateLimiter()
+	return NewPriorityTaskQueueWithLimiter(name, resource, syncFn, rl)
+}
+
+// NewPriorityTaskQueueWithLimiter creates a new PriorityTaskQueue with the
+// given sync function and rate limiter.
+func NewPriorityTaskQueueWithLimiter(name, resource string, syncFn SyncFunc, rl workqueue.RateLimiter) *PriorityTaskQueue {
+	tqmetrics.Register()
+
+	// See the tqmetrics package doc for why this must be resource, not name.
+	return &PriorityTaskQueue{
+		resource:     resource,
+		keyFunc:      KeyFunc,
+		sync:         syncFn,
+		logger:       klog.Background(),
+		queue:        workqueue.NewNamedRateLimitingQueue(rl, resource),
+		pending:      map[string]*priorityItem{},
+		wake:         make(chan struct{}, 1),
+		stopC:        make(chan struct{}),
+		dispatchDone: make(chan struct{}),
+		workerDone:   make(chan struct{}),
+	}
+}
+
+// Run starts the priority dispatcher and processes items in priority order
+// until Shutdown is called.
+func (t *PriorityTaskQueue) Run() {
+	go t.dispatchLoop()
+
+	for {
+		key, quit := t.queue.Get()
+		if quit {
+			t.logger.V(2).Info("Worker shutting down", "resource", t.resource)
+			close(t.workerDone)
+			return
+		}
+
+		k := key.(string)
+		itemLogger := t.logger.WithValues(
+			"resource", t.resource,
+			"key", k,
+			"attempt", t.queue.NumRequeues(key)+1,
+			"queueLen", t.queue.Len(),
+		)
+		itemLogger.V(4).Info("Syncing")
+
+		ctx := klog.NewContext(context.Background(), itemLogger)
+		if err := t.sync(ctx, k); err != nil {
+			itemLogger.Error(err, "Requeuing after sync error")
+			tqmetrics.IncSyncErrors(t.resource)
+			t.queue.AddRateLimited(key)
+		} else {
+			itemLogger.V(4).Info("Finished syncing")
+			t.queue.Forget(key)
+		}
+		t.queue.Done(key)
+	}
+}
+
+// dispatchLoop hands the highest-priority due key to t.queue as soon as it
+// becomes ready, re-checking whenever the heap changes or its earliest
+// item's deadline arrives.
+func (t *PriorityTaskQueue) dispatchLoop() {
+	defer close(t.dispatchDone)
+
+	for {
+		t.mu.Lock()
+		var timerC <-chan time.Time
+		if len(t.waiting) > 0 {
+			item := t.waiting[0]
+			wait := time.Until(item.readyAt)
+			if wait <= 0 {
+				heap.Pop(&t.waiting)
+				delete(t.pending, item.key)
+				t.mu.Unlock()
+				t.queue.Add(item.key)
+				continue
+			}
+			timerC = time.After(wait)
+		}
+		t.mu.Unlock()
+
+		select {
+		case <-t.stopC:
+			return
+		case <-t.wake:
+		case <-timerC:
+		}
+	}
+}
+
+// enqueue adds or updates key in the pending heap with the given priority,
+// becoming due after delay. A repeated enqueue of a key still waiting to be
+// dispatched raises its priority to the max seen and pulls readyAt forward
+// to the earliest requested time, rather than creating a second entry.
+func (t *PriorityTaskQueue) enqueue(key string, prio Priority, delay time.Duration) {
+	if delay < coalesceWindow {
+		delay = coalesceWindow
+	}
+	readyAt := time.Now().Add(delay)
+
+	t.mu.Lock()
+	if item, exists := t.pending[key]; exists {
+		if prio > item.priority {
+			item.priority = prio
+		}
+		if readyAt.Before(item.readyAt) {
+			item.readyAt = readyAt
+		}
+		heap.Fix(&t.waiting, item.index)
+	} else {
+		item := &priorityItem{key: key, priority: prio, readyAt: readyAt, seq: t.seq}
+		t.seq++
+		t.pending[key] = item
+		heap.Push(&t.waiting, item)
+	}
+	t.mu.Unlock()
+
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Enqueue enqueues one or more keys at PriorityGC, the tier used when no
+// priority is given. An object whose key can't be computed is logged and
+// skipped; the remaining objects are still enqueued.
+func (t *PriorityTaskQueue) Enqueue(objs ...interface{}) {
+	for _, obj := range objs {
+		key, err := t.keyFunc(obj)
+		if err != nil {
+			t.logger.Error(err, "Couldn't get key for object", "object", obj, "type", fmt.Sprintf("%T", obj))
+			continue
+		}
+		t.enqueue(key, PriorityGC, 0)
+	}
+}
+
+// EnqueueAfter enqueues obj at PriorityGC once delay has elapsed.
+func (t *PriorityTaskQueue) EnqueueAfter(obj interface{}, delay time.Duration) {
+	key, err := t.keyFunc(obj)
+	if err != nil {
+		t.logger.Error(err, "Couldn't get key for object", "object", obj, "type", fmt.Sprintf("%T", obj))
+		return
+	}
+	t.enqueue(key, PriorityGC, delay)
+}
+
+// EnqueuePriority enqueues obj at the given Priority. A PriorityUserFacing
+// enqueue jumps ahead of any PriorityGC keys already waiting to be
+// dispatched.
+func (t *PriorityTaskQueue) EnqueuePriority(obj interface{}, prio Priority) {
+	key, err := t.keyFunc(obj)
+	if err != nil {
+		t.logger.Error(err, "Couldn't get key for object", "object", obj, "type", fmt.Sprintf("%T", obj))
+		return
+	}
+	t.enqueue(key, prio, 0)
+}
+
+// Shutdown stops the dispatcher, shuts down the underlying work queue and
+// waits for the worker to ACK.
+func (t *PriorityTaskQueue) Shutdown() {
+	t.stopOnce.Do(func() { close(t.stopC) })
+	<-t.dispatchDone
+	t.logger.V(2).Info("Shutdown", "resource", t.resource)
+	t.queue.ShutDown()
+	<-t.workerDone
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestPriorityTaskQueue() *PriorityTaskQueue {
+	return NewPriorityTaskQueue("", "test-resource", func(ctx context.Context, key string) error { return nil })
+}
+
+// TestPriorityTaskQueueOrdering checks that a PriorityUserFacing enqueue
+// jumps ahead of PriorityGC keys already pending, without starting Run's
+// goroutines (which would make ordering a race between the test goroutine
+// and the dispatcher).
+func TestPriorityTaskQueueOrdering(t *testing.T) {
+	q := newTestPriorityTaskQueue()
+
+	q.enqueue("gc-1", PriorityGC, 0)
+	q.enqueue("gc-2", PriorityGC, 0)
+	q.enqueue("user-1", PriorityUserFacing, 0)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiting) != 3 {
+		t.Fatalf("len(waiting) = %d, want 3", len(q.waiting))
+	}
+	if q.waiting[0].key != "user-1" {
+		t.Errorf("waiting[0].key = %q, want %q (PriorityUserFacing should preempt PriorityGC)", q.waiting[0].key, "user-1")
+	}
+}
+
+// TestPriorityTaskQueueCoalescing checks that repeated enqueues of the same
+// key before it becomes due collapse into a single pending entry. This
+// relies on enqueue's coalesceWindow floor, not on goroutine timing, so it's
+// asserted directly against the pending map/heap with no Run() involved.
+func TestPriorityTaskQueueCoalescing(t *testing.T) {
+	q := newTestPriorityTaskQueue()
+
+	for i := 0; i < 5; i++ {
+		q.enqueue("same-key", PriorityGC, 0)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) != 1 {
+		t.Errorf("len(pending) = %d, want 1 (repeated enqueues should coalesce)", len(q.pending))
+	}
+	if len(q.waiting) != 1 {
+		t.Errorf("len(waiting) = %d, want 1 (repeated enqueues should coalesce)", len(q.waiting))
+	}
+}
+
+// TestPriorityTaskQueueCoalescingRaisesPriority checks that coalescing a
+// pending PriorityGC entry with a later PriorityUserFacing enqueue of the
+// same key raises its priority in place instead of leaving it stuck behind
+// other PriorityGC work.
+func TestPriorityTaskQueueCoalescingRaisesPriority(t *testing.T) {
+	q := newTestPriorityTaskQueue()
+
+	q.enqueue("same-key", PriorityGC, 0)
+	q.enqueue("same-key", PriorityUserFacing, 0)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item, ok := q.pending["same-key"]
+	if !ok {
+		t.Fatalf("pending[%q] missing", "same-key")
+	}
+	if item.priority != PriorityUserFacing {
+		t.Errorf("priority = %v, want %v (coalesced enqueue should raise priority)", item.priority, PriorityUserFacing)
+	}
+}
+
+// TestPriorityTaskQueueRunDispatchesInPriorityOrder runs the real
+// dispatcher and worker goroutines (unlike the enqueue()-only tests above)
+// and checks a PriorityUserFacing enqueue is dispatched ahead of PriorityGC
+// keys enqueued just before it. This is deterministic rather than a race
+// against freshly-spawned goroutines because enqueue floors every delay to
+// coalesceWindow: all three calls below run synchronously on this goroutine
+// in microseconds, so they're in the pending heap together long before the
+// dispatcher's timer can fire.
+func TestPriorityTaskQueueRunDispatchesInPriorityOrder(t *testing.T) {
+	var mu sync.Mutex
+	var synced []string
+	done := make(chan struct{})
+
+	q := NewPriorityTaskQueue("", "test-resource", func(ctx context.Context, key string) error {
+		mu.Lock()
+		synced = append(synced, key)
+		n := len(synced)
+		mu.Unlock()
+		if n == 3 {
+			close(done)
+		}
+		return nil
+	})
+
+	go q.Run()
+	defer q.Shutdown()
+
+	q.EnqueuePriority(cache.ExplicitKey("gc-1"), PriorityGC)
+	q.EnqueuePriority(cache.ExplicitKey("gc-2"), PriorityGC)
+	q.EnqueuePriority(cache.ExplicitKey("user-1"), PriorityUserFacing)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for syncs")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if synced[0] != "user-1" {
+		t.Errorf("synced[0] = %q, want %q (PriorityUserFacing should preempt PriorityGC)", synced[0], "user-1")
+	}
+}
+
+// TestPriorityTaskQueueRunCoalesces runs the real dispatcher and worker
+// goroutines and checks that repeated enqueues of the same key, issued
+// synchronously well within coalesceWindow, reach SyncFunc exactly once.
+func TestPriorityTaskQueueRunCoalesces(t *testing.T) {
+	var mu sync.Mutex
+	var syncCount int
+	done := make(chan struct{})
+
+	q := NewPriorityTaskQueue("", "test-resource", func(ctx context.Context, key string) error {
+		mu.Lock()
+		syncCount++
+		mu.Unlock()
+		close(done)
+		return nil
+	})
+
+	go q.Run()
+	defer q.Shutdown()
+
+	for i := 0; i < 5; i++ {
+		q.Enqueue(cache.ExplicitKey("same-key"))
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for sync")
+	}
+
+	// Give a duplicate dispatch - if coalescing had failed and the key had
+	// been queued more than once - a moment to show up before asserting.
+	time.Sleep(2 * coalesceWindow)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if syncCount != 1 {
+		t.Errorf("syncCount = %d, want 1 (repeated enqueues should coalesce)", syncCount)
+	}
+}
@@ -17,22 +17,71 @@ limitations under the License.
 package utils
 
 import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
-	"k8s.io/klog"
+	"k8s.io/klog/v2"
+
+	tqmetrics "k8s.io/ingress-gce/pkg/utils/metrics"
 )
 
 var (
 	KeyFunc = cache.DeletionHandlingMetaNamespaceKeyFunc
 )
 
+// drainPollInterval is how often Drain checks whether the queue has
+// emptied out while waiting for its deadline.
+const drainPollInterval = 100 * time.Millisecond
+
+// SyncFunc is invoked by a PeriodicTaskQueue for every item popped off its
+// work queue. The supplied context carries a per-item logr.Logger (fetch it
+// with klog.FromContext(ctx)) already populated with resource/key/attempt
+// values, so implementations can log without re-deriving that context.
+type SyncFunc func(ctx context.Context, key string) error
+
 // TaskQueue is a rate limited operation queue.
 type TaskQueue interface {
 	Run()
 	Enqueue(objs ...interface{})
+	// EnqueueAfter enqueues obj after delay has elapsed.
+	EnqueueAfter(obj interface{}, delay time.Duration)
+	// EnqueuePriority enqueues obj at the given Priority. Implementations
+	// that don't support priority tiers treat this the same as Enqueue.
+	EnqueuePriority(obj interface{}, prio Priority)
 	Shutdown()
 }
 
+// Stats is a point-in-time snapshot of a PeriodicTaskQueue's health, meant
+// to be polled from readiness/liveness probes.
+type Stats struct {
+	// Depth is the number of items waiting in the queue, not counting
+	// items currently being synced.
+	Depth int
+	// Inflight is the number of items currently being synced.
+	Inflight int
+	// Retries is the cumulative number of items requeued due to a sync
+	// error since the queue was created.
+	Retries int64
+}
+
+// Option configures optional behavior of a PeriodicTaskQueue at
+// construction time.
+type Option func(*PeriodicTaskQueue)
+
+// WithLogger overrides the base logr.Logger that the queue derives its
+// per-item, structured log entries from. If unset, the queue falls back to
+// klog's global logger.
+func WithLogger(logger logr.Logger) Option {
+	return func(t *PeriodicTaskQueue) {
+		t.logger = logger
+	}
+}
+
 // PeriodicTaskQueue invokes the given sync function for every work item
 // inserted. If the sync() function results in an error, the item is put on
 // the work queue after a rate-limit.
@@ -44,25 +93,96 @@ type PeriodicTaskQueue struct {
 	// queue is the work queue the worker polls.
 	queue workqueue.RateLimitingInterface
 	// sync is called for each item in the queue.
-	sync func(string) error
+	sync SyncFunc
+	// logger is the base logger that per-item loggers are derived from.
+	logger logr.Logger
 	// workerDone is closed when the worker exits.
 	workerDone chan struct{}
+
+	// mu guards draining, inflight, retries, retrying and cancel below.
+	mu sync.Mutex
+	// draining is set by Drain to stop accepting new items.
+	draining bool
+	// inflight is the number of items currently being synced.
+	inflight int
+	// retries is the cumulative number of requeues due to sync errors.
+	retries int64
+	// retrying holds keys that failed and are waiting out their rate
+	// limiter backoff before reappearing in queue. workqueue's delaying
+	// queue doesn't surface these in Len(), so Stats/Drain track them
+	// here explicitly instead of treating the queue as empty.
+	retrying map[string]struct{}
+	// cancel cancels the context passed to the running worker's sync
+	// calls; set once RunWithContext starts, used by Drain on timeout.
+	cancel context.CancelFunc
 }
 
 // Run the task queue. This will block until the Shutdown() has been called.
 func (t *PeriodicTaskQueue) Run() {
+	t.RunWithContext(context.Background())
+}
+
+// RunWithContext runs the task queue like Run, but propagates ctx into
+// every SyncFunc call so that callers can cancel in-flight syncs, e.g. on
+// losing a leader-election lease. This blocks until Shutdown() is called or
+// ctx is canceled.
+func (t *PeriodicTaskQueue) RunWithContext(ctx context.Context) {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	t.mu.Lock()
+	t.cancel = cancel
+	t.mu.Unlock()
+
+	stopWatchingCtx := make(chan struct{})
+	defer close(stopWatchingCtx)
+	go func() {
+		select {
+		case <-runCtx.Done():
+			t.queue.ShutDown()
+		case <-stopWatchingCtx:
+		}
+	}()
+
 	for {
 		key, quit := t.queue.Get()
 		if quit {
+			t.logger.V(2).Info("Worker shutting down", "resource", t.resource)
 			close(t.workerDone)
 			return
 		}
-		klog.V(4).Infof("Syncing %v (%v)", key, t.resource)
-		if err := t.sync(key.(string)); err != nil {
-			klog.Errorf("Requeuing %q due to error: %v (%v)", key, err, t.resource)
+
+		k := key.(string)
+		itemLogger := t.logger.WithValues(
+			"resource", t.resource,
+			"key", k,
+			"attempt", t.queue.NumRequeues(key)+1,
+			"queueLen", t.queue.Len(),
+		)
+		itemLogger.V(4).Info("Syncing")
+
+		t.mu.Lock()
+		delete(t.retrying, k)
+		t.inflight++
+		t.mu.Unlock()
+
+		itemCtx := klog.NewContext(runCtx, itemLogger)
+		err := t.sync(itemCtx, k)
+
+		t.mu.Lock()
+		t.inflight--
+		t.mu.Unlock()
+
+		if err != nil {
+			itemLogger.Error(err, "Requeuing after sync error")
+			tqmetrics.IncSyncErrors(t.resource)
+			t.mu.Lock()
+			t.retries++
+			t.retrying[k] = struct{}{}
+			t.mu.Unlock()
 			t.queue.AddRateLimited(key)
 		} else {
-			klog.V(4).Infof("Finished syncing %v", key)
+			itemLogger.V(4).Info("Finished syncing")
 			t.queue.Forget(key)
 		}
 		t.queue.Done(key)
@@ -71,45 +191,138 @@ func (t *PeriodicTaskQueue) Run() {
 
 // Enqueue one or more keys to the work queue.
 func (t *PeriodicTaskQueue) Enqueue(objs ...interface{}) {
+	t.mu.Lock()
+	draining := t.draining
+	t.mu.Unlock()
+	if draining {
+		t.logger.V(4).Info("Dropping enqueue, queue is draining", "resource", t.resource)
+		return
+	}
+
 	for _, obj := range objs {
 		key, err := t.keyFunc(obj)
 		if err != nil {
-			klog.Errorf("Couldn't get key for object %+v (type %T): %v", obj, obj, err)
+			t.logger.Error(err, "Couldn't get key for object", "object", obj, "type", fmt.Sprintf("%T", obj))
 			return
 		}
-		klog.V(4).Infof("Enqueue key=%q (%v)", key, t.resource)
+		t.logger.V(4).Info("Enqueue", "resource", t.resource, "key", key)
 		t.queue.Add(key)
 	}
 }
 
+// EnqueueAfter enqueues a key once delay has elapsed.
+func (t *PeriodicTaskQueue) EnqueueAfter(obj interface{}, delay time.Duration) {
+	t.mu.Lock()
+	draining := t.draining
+	t.mu.Unlock()
+	if draining {
+		t.logger.V(4).Info("Dropping enqueue, queue is draining", "resource", t.resource)
+		return
+	}
+
+	key, err := t.keyFunc(obj)
+	if err != nil {
+		t.logger.Error(err, "Couldn't get key for object", "object", obj, "type", fmt.Sprintf("%T", obj))
+		return
+	}
+	t.logger.V(4).Info("EnqueueAfter", "resource", t.resource, "key", key, "delay", delay)
+	t.queue.AddAfter(key, delay)
+}
+
+// EnqueuePriority enqueues obj like Enqueue. PeriodicTaskQueue has no
+// priority tiers of its own, so prio is accepted only for TaskQueue
+// interface compatibility; see PriorityTaskQueue for a queue that honors it.
+func (t *PeriodicTaskQueue) EnqueuePriority(obj interface{}, prio Priority) {
+	t.Enqueue(obj)
+}
+
 // Shutdown shuts down the work queue and waits for the worker to ACK
 func (t *PeriodicTaskQueue) Shutdown() {
-	klog.V(2).Infof("Shutdown")
+	t.logger.V(2).Info("Shutdown", "resource", t.resource)
 	t.queue.ShutDown()
 	<-t.workerDone
 }
 
+// Drain stops accepting new items via Enqueue and blocks until the queue
+// has fully emptied (no items waiting, none in flight) or timeout elapses.
+// If the deadline is reached first, the context RunWithContext passes to
+// SyncFunc is canceled so in-flight calls can observe it and cancel cleanly;
+// that cancellation also shuts down the work queue, so the worker exits once
+// the in-flight sync returns. When the queue empties before the deadline,
+// Drain leaves the worker running; callers that want it to exit should call
+// Shutdown once Drain returns.
+func (t *PeriodicTaskQueue) Drain(timeout time.Duration) {
+	t.mu.Lock()
+	t.draining = true
+	t.mu.Unlock()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		stats := t.Stats()
+		if stats.Depth == 0 && stats.Inflight == 0 {
+			return
+		}
+
+		select {
+		case <-deadline.C:
+			t.logger.V(2).Info("Drain deadline exceeded, canceling in-flight syncs", "resource", t.resource)
+			t.mu.Lock()
+			cancel := t.cancel
+			t.mu.Unlock()
+			if cancel != nil {
+				cancel()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stats reports a point-in-time snapshot of queue depth, in-flight syncs
+// and cumulative retries, suitable for readiness/liveness probes. Depth
+// includes keys that are waiting out a rate-limiter backoff after a failed
+// sync, not just those workqueue.Len() already considers ready.
+func (t *PeriodicTaskQueue) Stats() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return Stats{
+		Depth:    t.queue.Len() + len(t.retrying),
+		Inflight: t.inflight,
+		Retries:  t.retries,
+	}
+}
+
 // NewPeriodicTaskQueue creates a new task queue with the default rate limiter.
-func NewPeriodicTaskQueue(name, resource string, syncFn func(string) error) *PeriodicTaskQueue {
+func NewPeriodicTaskQueue(name, resource string, syncFn SyncFunc, opts ...Option) *PeriodicTaskQueue {
 	rl := workqueue.DefaultControllerRateLimiter()
-	return NewPeriodicTaskQueueWithLimiter(name, resource, syncFn, rl)
+	return NewPeriodicTaskQueueWithLimiter(name, resource, syncFn, rl, opts...)
 }
 
 // NewPeriodicTaskQueueWithLimiter creates a new task queue with the given sync function
 // and rate limiter. The sync function is called for every element inserted into the queue.
-func NewPeriodicTaskQueueWithLimiter(name, resource string, syncFn func(string) error, rl workqueue.RateLimiter) *PeriodicTaskQueue {
-	var queue workqueue.RateLimitingInterface
-	if name == "" {
-		queue = workqueue.NewRateLimitingQueue(rl)
-	} else {
-		queue = workqueue.NewNamedRateLimitingQueue(rl, name)
-	}
+func NewPeriodicTaskQueueWithLimiter(name, resource string, syncFn SyncFunc, rl workqueue.RateLimiter, opts ...Option) *PeriodicTaskQueue {
+	tqmetrics.Register()
+
+	// See the tqmetrics package doc for why this must be resource, not name.
+	queue := workqueue.NewNamedRateLimitingQueue(rl, resource)
 
-	return &PeriodicTaskQueue{
+	t := &PeriodicTaskQueue{
 		resource:   resource,
 		keyFunc:    KeyFunc,
 		queue:      queue,
 		sync:       syncFn,
+		logger:     klog.Background(),
+		retrying:   map[string]struct{}{},
 		workerDone: make(chan struct{}),
 	}
+
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	return t
 }
@@ -0,0 +1,257 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// testLogEntry is one recorded Info/Error call.
+type testLogEntry struct {
+	msg           string
+	keysAndValues []interface{}
+}
+
+// testLogSink is a minimal logr.LogSink that records every Info/Error call,
+// merging in whatever WithValues accumulated along the way, so tests can
+// assert on the structured values PeriodicTaskQueue attaches per item.
+type testLogSink struct {
+	mu      *sync.Mutex
+	entries *[]testLogEntry
+	values  []interface{}
+}
+
+func newTestLogSink() *testLogSink {
+	return &testLogSink{mu: &sync.Mutex{}, entries: &[]testLogEntry{}}
+}
+
+func (s *testLogSink) Init(info logr.RuntimeInfo) {}
+func (s *testLogSink) Enabled(level int) bool     { return true }
+
+func (s *testLogSink) Info(level int, msg string, keysAndValues ...interface{}) {
+	s.record(msg, keysAndValues)
+}
+
+func (s *testLogSink) Error(err error, msg string, keysAndValues ...interface{}) {
+	s.record(msg, append([]interface{}{"error", err}, keysAndValues...))
+}
+
+func (s *testLogSink) record(msg string, keysAndValues []interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all := append(append([]interface{}{}, s.values...), keysAndValues...)
+	*s.entries = append(*s.entries, testLogEntry{msg: msg, keysAndValues: all})
+}
+
+func (s *testLogSink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	return &testLogSink{
+		mu:      s.mu,
+		entries: s.entries,
+		values:  append(append([]interface{}{}, s.values...), keysAndValues...),
+	}
+}
+
+func (s *testLogSink) WithName(name string) logr.LogSink { return s }
+
+func (s *testLogSink) find(msg string) (testLogEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range *s.entries {
+		if e.msg == msg {
+			return e, true
+		}
+	}
+	return testLogEntry{}, false
+}
+
+// valueFor returns the value paired with key in a logr keysAndValues list.
+func valueFor(keysAndValues []interface{}, key string) (interface{}, bool) {
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		if keysAndValues[i] == key {
+			return keysAndValues[i+1], true
+		}
+	}
+	return nil, false
+}
+
+// TestPeriodicTaskQueueStructuredLogging checks that WithLogger's logger is
+// used for per-item entries, that those entries carry the resource/key
+// values SyncFunc callers rely on, and that the context passed to SyncFunc
+// carries a klog logger populated with the same values.
+func TestPeriodicTaskQueueStructuredLogging(t *testing.T) {
+	sink := newTestLogSink()
+
+	var ctxLoggerResource interface{}
+	synced := make(chan struct{})
+	q := NewPeriodicTaskQueue("", "test-resource", func(ctx context.Context, key string) error {
+		// klog.FromContext(ctx) should surface the same per-item logger
+		// RunWithContext logged "Syncing" with, proving the values threaded
+		// through the context rather than just to the log line.
+		ctxLogger := klog.FromContext(ctx)
+		ctxLoggerEntry, ok := ctxLogger.GetSink().(*testLogSink)
+		if ok {
+			ctxLoggerResource, _ = valueFor(ctxLoggerEntry.values, "resource")
+		}
+		close(synced)
+		return nil
+	}, WithLogger(logr.New(sink)))
+
+	go q.Run()
+	defer q.Shutdown()
+
+	q.Enqueue(cache.ExplicitKey("key-1"))
+
+	select {
+	case <-synced:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for sync")
+	}
+
+	if ctxLoggerResource != "test-resource" {
+		t.Errorf("logger from context has resource = %v, want %q", ctxLoggerResource, "test-resource")
+	}
+
+	entry, ok := sink.find("Syncing")
+	if !ok {
+		t.Fatal(`expected a "Syncing" log entry`)
+	}
+	if v, _ := valueFor(entry.keysAndValues, "resource"); v != "test-resource" {
+		t.Errorf(`"Syncing" resource = %v, want %q`, v, "test-resource")
+	}
+	if v, _ := valueFor(entry.keysAndValues, "key"); v != "key-1" {
+		t.Errorf(`"Syncing" key = %v, want %q`, v, "key-1")
+	}
+}
+
+// TestPeriodicTaskQueueDrainWaitsForEmptyQueue checks that Drain blocks
+// while a sync is in flight and returns once it finishes, well before its
+// deadline.
+func TestPeriodicTaskQueueDrainWaitsForEmptyQueue(t *testing.T) {
+	release := make(chan struct{})
+	syncStarted := make(chan struct{})
+	q := NewPeriodicTaskQueue("", "test-resource", func(ctx context.Context, key string) error {
+		close(syncStarted)
+		<-release
+		return nil
+	})
+
+	go q.Run()
+	defer q.Shutdown()
+
+	q.Enqueue(cache.ExplicitKey("key-1"))
+
+	select {
+	case <-syncStarted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for sync to start")
+	}
+
+	drainDone := make(chan struct{})
+	go func() {
+		q.Drain(5 * time.Second)
+		close(drainDone)
+	}()
+
+	select {
+	case <-drainDone:
+		t.Fatal("Drain returned while a sync was still in flight")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+
+	select {
+	case <-drainDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Drain did not return after the in-flight sync finished")
+	}
+}
+
+// TestPeriodicTaskQueueDrainDeadlineCancelsContext checks that once Drain's
+// deadline elapses without the queue emptying, it cancels the context
+// RunWithContext passes to SyncFunc so an in-flight sync can observe it.
+func TestPeriodicTaskQueueDrainDeadlineCancelsContext(t *testing.T) {
+	syncStarted := make(chan struct{})
+	ctxCanceled := make(chan struct{})
+	q := NewPeriodicTaskQueue("", "test-resource", func(ctx context.Context, key string) error {
+		close(syncStarted)
+		<-ctx.Done()
+		close(ctxCanceled)
+		return ctx.Err()
+	})
+
+	go q.RunWithContext(context.Background())
+	defer q.Shutdown()
+
+	q.Enqueue(cache.ExplicitKey("key-1"))
+
+	select {
+	case <-syncStarted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for sync to start")
+	}
+
+	drainDone := make(chan struct{})
+	go func() {
+		q.Drain(50 * time.Millisecond)
+		close(drainDone)
+	}()
+
+	select {
+	case <-drainDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Drain did not return after its deadline elapsed")
+	}
+
+	select {
+	case <-ctxCanceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SyncFunc's context was never canceled by Drain's deadline")
+	}
+}
+
+// TestPeriodicTaskQueueStatsIncludesRetrying checks that Stats.Depth counts
+// keys waiting out a rate-limiter backoff, not just workqueue.Len(), since a
+// failed sync's retry is invisible to Len() until its delay elapses.
+func TestPeriodicTaskQueueStatsIncludesRetrying(t *testing.T) {
+	q := NewPeriodicTaskQueue("", "test-resource", func(ctx context.Context, key string) error { return nil })
+
+	q.queue.Add("queued-key")
+	q.mu.Lock()
+	q.retrying["retrying-key"] = struct{}{}
+	q.retries = 3
+	q.inflight = 2
+	q.mu.Unlock()
+
+	stats := q.Stats()
+	if stats.Depth != 2 {
+		t.Errorf("Depth = %d, want 2 (1 queued + 1 backed-off retry)", stats.Depth)
+	}
+	if stats.Inflight != 2 {
+		t.Errorf("Inflight = %d, want 2", stats.Inflight)
+	}
+	if stats.Retries != 3 {
+		t.Errorf("Retries = %d, want 3", stats.Retries)
+	}
+}